@@ -0,0 +1,1019 @@
+// Package definitions exposes a steve handler that describes the OpenAPI schema backing a given
+// steve schema, so that API consumers can discover the full set of fields a type supports.
+package definitions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	openapi_v2 "github.com/google/gnostic-models/openapiv2"
+	openapi_v3 "github.com/google/gnostic-models/openapiv3"
+	"github.com/rancher/apiserver/pkg/apierror"
+	"github.com/rancher/apiserver/pkg/types"
+	"github.com/rancher/wrangler/v2/pkg/schemas/validation"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/openapi"
+	"k8s.io/kube-openapi/pkg/util/proto"
+)
+
+// crdGroupVersionResource identifies the CustomResourceDefinition resource itself, used to fetch a
+// CRD's authored schema through the dynamic client.
+var crdGroupVersionResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// definitionField holds information about a single field on a definition. Type/SubType/Description/
+// Required come from the apiserver's OpenAPI document for every field; the rest are only populated
+// for CRD-backed fields, sourced from the CRD's authored openAPIV3Schema.
+type definitionField struct {
+	Type        string `json:"type"`
+	SubType     string `json:"subType,omitempty"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+
+	Enum                  []string    `json:"enum,omitempty"`
+	Pattern               string      `json:"pattern,omitempty"`
+	Minimum               *float64    `json:"minimum,omitempty"`
+	Maximum               *float64    `json:"maximum,omitempty"`
+	Default               interface{} `json:"default,omitempty"`
+	Format                string      `json:"format,omitempty"`
+	Nullable              bool        `json:"nullable,omitempty"`
+	PreserveUnknownFields bool        `json:"preserveUnknownFields,omitempty"`
+	ListType              string      `json:"listType,omitempty"`
+	ListMapKeys           []string    `json:"listMapKeys,omitempty"`
+	IntOrString           bool        `json:"intOrString,omitempty"`
+}
+
+// definition holds the full set of resource fields for a given type, plus metadata about that type.
+type definition struct {
+	ResourceFields map[string]definitionField `json:"resourceFields"`
+	Type           string                     `json:"type"`
+	Description    string                     `json:"description,omitempty"`
+}
+
+// schemaDefinition is the object returned when a schema's definition is requested through the API.
+type schemaDefinition struct {
+	DefinitionType string                `json:"definitionType"`
+	Definitions    map[string]definition `json:"definitions"`
+}
+
+// SchemaDefinitionHandler serves schemaDefinitions - full descriptions of the fields available on a
+// given schema, sourced from the OpenAPI document(s) published by the apiserver. It must be
+// periodically refreshed (see Refresh) to pick up new and changed schemas.
+type SchemaDefinitionHandler struct {
+	sync.RWMutex
+	client    discovery.DiscoveryInterface
+	crdClient dynamic.Interface
+
+	// models holds the OpenAPI v2/v3 models for every GroupVersion steve knows about. Keeping
+	// models per-GV (rather than one document for the whole cluster) lets Refresh fetch only
+	// what changed and lets byIDHandler avoid scanning definitions that belong to other GVs.
+	models map[schema.GroupVersion]*proto.Models
+
+	// schemaToModel maps a steve schema ID (e.g. "management.cattle.io.globalrole") to the name
+	// of the model that describes it within its GroupVersion's models.
+	schemaToModel map[string]string
+
+	// modelGV records which GroupVersion a given model name was sourced from, so byIDHandler can
+	// find the right entry in models without re-deriving it from the schema ID.
+	modelGV map[string]schema.GroupVersion
+
+	// v2Models is the cached, parsed cluster-wide OpenAPI v2 document, used as the fallback source
+	// for any GV that OpenAPI v3 doesn't cover. v2DocHash is a fingerprint of the last document this
+	// was parsed from, so an unchanged document doesn't pay the cost of being re-parsed.
+	v2Models  *proto.Models
+	v2DocHash string
+
+	// fingerprints holds the last-seen fingerprint (a v3 content hash, or the shared v2DocHash) for
+	// every GV whose models we've successfully built, so Refresh can skip rebuilding GVs that
+	// haven't changed since the last call.
+	fingerprints map[schema.GroupVersion]string
+
+	// crdSchemas holds the CRD-authored openAPIV3Schema for every model backed by a
+	// CustomResourceDefinition, keyed by model name. It's merged on top of the apiserver's
+	// aggregated OpenAPI document when building a definition, since CRD authors can express things
+	// (enums, patterns, defaults, x-kubernetes-* extensions) that the aggregated doc strips.
+	crdSchemas map[string]*apiextv1.JSONSchemaProps
+
+	// categories maps a discovery category (e.g. "all", or any other value an APIResource reports
+	// in its Categories field) to the schema IDs of every resource that belongs to it, so byIDHandler
+	// can serve a synthesized, virtual schemaDefinition for the category itself.
+	categories map[string][]string
+
+	// gvSchemaIDs records the set of schemaToModel entries each GV currently contributes, so that a
+	// later Refresh can prune the entries it no longer contributes (a CRD or resource deleted from the
+	// cluster, a whole group/version retired) instead of leaving them cached forever.
+	gvSchemaIDs map[schema.GroupVersion]map[string]bool
+
+	// schemaCategories records the discovery categories each primary-resource schemaID belonged to as
+	// of the last time it was observed, so Refresh can detect a resource dropping a category (without
+	// the resource itself disappearing) and remove the stale membership from categories - something
+	// gvSchemaIDs alone, which only tracks whole-schemaID add/remove, wouldn't catch.
+	schemaCategories map[string][]string
+}
+
+// NewSchemaDefinitionHandler creates a new SchemaDefinitionHandler. crdClient may be nil, in which
+// case definitions are built from the aggregated OpenAPI document alone. Refresh must be called at
+// least once before the handler will serve any definitions.
+func NewSchemaDefinitionHandler(client discovery.DiscoveryInterface, crdClient dynamic.Interface) *SchemaDefinitionHandler {
+	return &SchemaDefinitionHandler{
+		client:    client,
+		crdClient: crdClient,
+	}
+}
+
+// byIDHandler looks up the schemaDefinition for the schema identified by request.Name.
+func (h *SchemaDefinitionHandler) byIDHandler(request *types.APIRequest) (types.APIObject, error) {
+	h.RLock()
+	defer h.RUnlock()
+
+	id := request.Name
+	if h.schemaToModel == nil || h.models == nil {
+		return types.APIObject{}, apierror.NewAPIError(serviceUnavailable, "schema definitions are not yet available, try again later")
+	}
+
+	// A request for a subresource's definition arrives as "{schemaID}/{subresource}" (e.g.
+	// "apps.deployment/scale"), but Refresh indexes subresources under the dotted composite key it
+	// uses everywhere else (e.g. "apps.deployment.status"). Translate before looking it up.
+	lookupID := id
+	if schemaID, subresource, ok := splitSubresourceName(id); ok {
+		lookupID = schemaID + "." + subresource
+	}
+
+	modelName, ok := h.schemaToModel[lookupID]
+	if !ok {
+		if schemaIDs, ok := h.categories[id]; ok {
+			return h.categoryDefinition(id, schemaIDs), nil
+		}
+		if request.Schemas == nil || request.Schemas.LookupSchema(id) == nil {
+			return types.APIObject{}, apierror.NewAPIError(validation.NotFound, fmt.Sprintf("%s is not a known schema", id))
+		}
+		return types.APIObject{}, apierror.NewAPIError(serviceUnavailable, fmt.Sprintf("definition for %s not found, it may not have been refreshed yet", id))
+	}
+
+	gv := h.modelGV[modelName]
+	models := h.models[gv]
+	if models == nil {
+		return types.APIObject{}, apierror.NewAPIError(serviceUnavailable, fmt.Sprintf("models for %s not found, it may not have been refreshed yet", gv.String()))
+	}
+
+	model := (*models).LookupModel(modelName)
+	if model == nil {
+		return types.APIObject{}, apierror.NewAPIError(serviceUnavailable, fmt.Sprintf("model %s for schema %s not found, it may not have been refreshed yet", modelName, id))
+	}
+	kind, ok := model.(*proto.Kind)
+	if !ok {
+		return types.APIObject{}, apierror.NewAPIError(validation.ServerError, fmt.Sprintf("model %s for schema %s is not a valid kind", modelName, id))
+	}
+
+	definitions := map[string]definition{}
+	addDefinition(*models, modelName, kind, h.crdSchemas[modelName], definitions)
+
+	return types.APIObject{
+		ID:   id,
+		Type: "schemaDefinition",
+		Object: schemaDefinition{
+			DefinitionType: modelName,
+			Definitions:    definitions,
+		},
+	}, nil
+}
+
+// categoryDefinition synthesizes a virtual schemaDefinition for a discovery category (e.g. "all"),
+// merging the definitions of every schema that belongs to it into a single response. Unlike a
+// concrete schema's definition, its DefinitionType isn't a real model name - it's an anonymous union
+// of every member type, since no single kind describes the category itself. Callers must hold at
+// least a read lock; entries in schemaIDs that can no longer be resolved (e.g. a stale model) are
+// silently skipped rather than failing the whole category.
+func (h *SchemaDefinitionHandler) categoryDefinition(category string, schemaIDs []string) types.APIObject {
+	definitions := map[string]definition{}
+	var memberTypes []string
+	for _, schemaID := range schemaIDs {
+		modelName, ok := h.schemaToModel[schemaID]
+		if !ok {
+			continue
+		}
+		models := h.models[h.modelGV[modelName]]
+		if models == nil {
+			continue
+		}
+		kind, ok := (*models).LookupModel(modelName).(*proto.Kind)
+		if !ok {
+			continue
+		}
+		addDefinition(*models, modelName, kind, h.crdSchemas[modelName], definitions)
+		memberTypes = append(memberTypes, modelName)
+	}
+	sort.Strings(memberTypes)
+
+	return types.APIObject{
+		ID:   category,
+		Type: "schemaDefinition",
+		Object: schemaDefinition{
+			DefinitionType: strings.Join(memberTypes, " | "),
+			Definitions:    definitions,
+		},
+	}
+}
+
+// serviceUnavailable is used for any case where the handler is well-formed but hasn't yet populated
+// (or has lost) the data needed to answer - callers should retry after the next Refresh.
+var serviceUnavailable = validation.ErrorCode{Status: http.StatusServiceUnavailable, Code: "ServiceUnavailable"}
+
+// addDefinition walks kind's fields, converting each to a definitionField and recursively adding any
+// referenced kinds to definitions. Already-visited kinds are skipped so that self-referential and
+// mutually-referential types don't cause infinite recursion. crdSchema, if non-nil, is the
+// CRD-authored openAPIV3Schema for this same type, and is merged onto each matching field.
+func addDefinition(models proto.Models, name string, kind *proto.Kind, crdSchema *apiextv1.JSONSchemaProps, definitions map[string]definition) {
+	if _, ok := definitions[name]; ok {
+		return
+	}
+	def := definition{
+		ResourceFields: map[string]definitionField{},
+		Type:           name,
+		Description:    kind.GetDescription(),
+	}
+	// Reserve the slot before recursing so a cycle back to this type is a no-op instead of infinite.
+	definitions[name] = def
+
+	required := map[string]bool{}
+	for _, name := range kind.RequiredFields {
+		required[name] = true
+	}
+	for _, fieldName := range kind.FieldOrder {
+		fieldSchema := kind.Fields[fieldName]
+		visitor := &fieldVisitor{models: models, definitions: definitions, crdProp: crdPropFor(crdSchema, fieldName)}
+		fieldSchema.Accept(visitor)
+		field := visitor.result
+		field.Description = fieldSchema.GetDescription()
+		field.Required = required[fieldName]
+		mergeCRDField(&field, visitor.crdProp)
+		def.ResourceFields[fieldName] = field
+	}
+	definitions[name] = def
+}
+
+// crdPropFor returns parent's authored schema for fieldName, or nil if parent doesn't describe it
+// (parent is nil for non-CRD-backed types, or the field simply isn't listed).
+func crdPropFor(parent *apiextv1.JSONSchemaProps, fieldName string) *apiextv1.JSONSchemaProps {
+	if parent == nil {
+		return nil
+	}
+	if prop, ok := parent.Properties[fieldName]; ok {
+		return &prop
+	}
+	return nil
+}
+
+// mergeCRDField overlays the CRD-authored extras onto field. It's a no-op when crdProp is nil, so
+// calling it for apiserver-only (non-CRD) types costs nothing beyond the nil check.
+func mergeCRDField(field *definitionField, crdProp *apiextv1.JSONSchemaProps) {
+	if crdProp == nil {
+		return
+	}
+	field.Pattern = crdProp.Pattern
+	field.Minimum = crdProp.Minimum
+	field.Maximum = crdProp.Maximum
+	field.Format = crdProp.Format
+	field.Nullable = crdProp.Nullable
+	field.IntOrString = crdProp.XIntOrString
+	if crdProp.XPreserveUnknownFields != nil {
+		field.PreserveUnknownFields = *crdProp.XPreserveUnknownFields
+	}
+	if crdProp.XListType != nil {
+		field.ListType = *crdProp.XListType
+	}
+	field.ListMapKeys = crdProp.XListMapKeys
+	if crdProp.Default != nil {
+		var def interface{}
+		if err := json.Unmarshal(crdProp.Default.Raw, &def); err == nil {
+			field.Default = def
+		}
+	}
+	for _, raw := range crdProp.Enum {
+		var value string
+		if err := json.Unmarshal(raw.Raw, &value); err != nil {
+			value = string(raw.Raw)
+		}
+		field.Enum = append(field.Enum, value)
+	}
+}
+
+// fieldVisitor converts a single proto.Schema field into a definitionField, recursing into
+// referenced models (via addDefinition) as it goes. crdProp, when set, is this field's own
+// CRD-authored schema and is threaded into any nested object addDefinition recurses into.
+type fieldVisitor struct {
+	models      proto.Models
+	definitions map[string]definition
+	crdProp     *apiextv1.JSONSchemaProps
+	result      definitionField
+}
+
+func (f *fieldVisitor) VisitArray(array *proto.Array) {
+	f.result.Type = "array"
+	var subCRDProp *apiextv1.JSONSchemaProps
+	if f.crdProp != nil && f.crdProp.Items != nil {
+		subCRDProp = f.crdProp.Items.Schema
+	}
+	sub := &fieldVisitor{models: f.models, definitions: f.definitions, crdProp: subCRDProp}
+	array.SubType.Accept(sub)
+	f.result.SubType = sub.result.Type
+}
+
+func (f *fieldVisitor) VisitMap(m *proto.Map) {
+	f.result.Type = "map"
+	var subCRDProp *apiextv1.JSONSchemaProps
+	if f.crdProp != nil && f.crdProp.AdditionalProperties != nil {
+		subCRDProp = f.crdProp.AdditionalProperties.Schema
+	}
+	sub := &fieldVisitor{models: f.models, definitions: f.definitions, crdProp: subCRDProp}
+	m.SubType.Accept(sub)
+	f.result.SubType = sub.result.Type
+}
+
+func (f *fieldVisitor) VisitPrimitive(primitive *proto.Primitive) {
+	f.result.Type = primitive.Type
+}
+
+func (f *fieldVisitor) VisitKind(kind *proto.Kind) {
+	// An inline (non-$ref) object - there's no model name to recurse into, just describe it as an object.
+	f.result.Type = "object"
+}
+
+func (f *fieldVisitor) VisitReference(ref proto.Reference) {
+	name := ref.Reference()
+	f.result.Type = name
+	if kind, ok := ref.SubSchema().(*proto.Kind); ok {
+		addDefinition(f.models, name, kind, f.crdProp, f.definitions)
+	}
+}
+
+// Refresh incrementally rebuilds the handler's models and schemaToModel/modelGV indices from the
+// apiserver's discovery and OpenAPI endpoints. It prefers per-GroupVersion OpenAPI v3 documents
+// (fetched on-demand for every GV discovery reports), falling back to the cluster-wide OpenAPI v2
+// document for any GV that v3 doesn't serve. ServerGroupsAndResources already consults the
+// aggregated discovery API (apidiscovery.k8s.io) when the apiserver advertises it, falling back to
+// the legacy group/version/resource endpoints itself.
+//
+// Refresh is incremental: each GV carries a fingerprint (a hash of its OpenAPI v3 content, or the
+// shared v2 document's hash for GVs served from it), and a GV whose fingerprint hasn't changed
+// since the last call is left untouched rather than re-parsed. If discovery only partially fails
+// (discovery.ErrGroupDiscoveryFailed), the GVs that did succeed are merged in, the GVs that didn't
+// keep whatever was already cached for them, and the partial failure is reported in the returned
+// error without invalidating any previously-built, still-valid entries.
+//
+// Being incremental cuts both ways: Refresh also prunes anything previously cached that discovery no
+// longer reports - a GV whose group/version was retired, a resource/CRD/subresource removed from a GV
+// that's still otherwise present, or a resource dropping a category it used to list - so that
+// byIDHandler doesn't keep serving definitions for resources that no longer exist. A GV affected by a
+// transient, partial discovery failure is exempt from this and keeps its stale-but-still-valid
+// entries, same as above. Tracking what's still present (as opposed to rebuilding models) is cheap -
+// it only needs this cycle's discovery resource lists, not a model scan or a live CRD fetch - so it's
+// done for every GV discovery reports, not just the ones whose models actually changed: discovery's
+// resource lists and the OpenAPI document they describe can drift independently of one another.
+func (h *SchemaDefinitionHandler) Refresh() error {
+	v2Doc, err := h.client.OpenAPISchema()
+	if err != nil {
+		return fmt.Errorf("unable to fetch openapi v2 schema: %w", err)
+	}
+
+	h.RLock()
+	v2Models := h.v2Models
+	v2DocHash := h.v2DocHash
+	models := copyModelMap(h.models)
+	schemaToModel := copyStringMap(h.schemaToModel)
+	modelGV := copyGVMap(h.modelGV)
+	fingerprints := copyFingerprintMap(h.fingerprints)
+	crdSchemas := copyCRDSchemaMap(h.crdSchemas)
+	categories := copyCategoryMap(h.categories)
+	schemaCategories := copyCategoryMap(h.schemaCategories)
+	gvSchemaIDs := copyGVSchemaIDsMap(h.gvSchemaIDs)
+	h.RUnlock()
+
+	if docHash := hashV2Document(v2Doc); v2Models == nil || docHash != v2DocHash {
+		parsed, parseErr := proto.NewOpenAPIData(v2Doc)
+		if parseErr != nil {
+			return fmt.Errorf("unable to parse openapi v2 schema: %w", parseErr)
+		}
+		v2Models = &parsed
+		v2DocHash = docHash
+	}
+
+	groups, resources, discoverErr := h.client.ServerGroupsAndResources()
+	groupDiscoveryErr, partial := discoverErr.(*discovery.ErrGroupDiscoveryFailed)
+	if discoverErr != nil && !partial {
+		return fmt.Errorf("unable to retrieve groups and resources: %w", discoverErr)
+	}
+
+	// failedGVs holds every GV a partial discovery failure reported - these are exempt from pruning
+	// below, since their absence from resources this cycle is a transient error, not a real removal.
+	failedGVs := map[schema.GroupVersion]bool{}
+	if partial {
+		for gv := range groupDiscoveryErr.Groups {
+			failedGVs[gv] = true
+		}
+	}
+
+	preferredVersions := map[string]string{}
+	for _, group := range groups {
+		preferredVersions[group.Name] = group.PreferredVersion.Version
+	}
+
+	v3Paths := fetchOpenAPIV3Paths(h.client)
+	var errs []error
+
+	// changedGVs records which GVs this Refresh actually rebuilt models for, so the indexing passes
+	// below can skip the expensive part of their work (a full model scan via buildGVKIndex, or a live
+	// CRD Get per APIResource) for every other GV whose models are untouched.
+	changedGVs := map[schema.GroupVersion]bool{}
+
+	// observedGVs holds every GV discovery still reports as the preferred version for its group this
+	// cycle, whether or not resolving its models actually succeeded - anything cached that isn't in
+	// here (and isn't in failedGVs) has genuinely disappeared and is pruned at the end of Refresh.
+	observedGVs := map[schema.GroupVersion]bool{}
+
+	// newGVSchemaIDs accumulates, for every GV discovery reports this cycle, the full set of
+	// schemaToModel entries (primary and subresource) its resourceList currently lists - used below to
+	// prune whatever that GV contributed last time but no longer does. Unlike changedGVs, this is
+	// populated regardless of whether the GV's models actually changed.
+	newGVSchemaIDs := map[schema.GroupVersion]map[string]bool{}
+	addGVSchemaID := func(gv schema.GroupVersion, schemaID string) {
+		if newGVSchemaIDs[gv] == nil {
+			newGVSchemaIDs[gv] = map[string]bool{}
+		}
+		newGVSchemaIDs[gv][schemaID] = true
+	}
+
+	for _, resourceList := range resources {
+		if resourceList == nil {
+			continue
+		}
+		gv, parseErr := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if parseErr != nil {
+			errs = append(errs, fmt.Errorf("unable to parse group version %s: %w", resourceList.GroupVersion, parseErr))
+			continue
+		}
+		if preferredVersions[gv.Group] != gv.Version {
+			continue
+		}
+		observedGVs[gv] = true
+
+		gvModels, fingerprint, changed, resolveErr := resolveGVModels(gv, v3Paths, v2Models, v2DocHash, fingerprints[gv])
+		if resolveErr != nil {
+			// Keep whatever was cached for this GV from a previous Refresh - stale but still valid.
+			errs = append(errs, fmt.Errorf("unable to refresh models for %s: %w", gv, resolveErr))
+			if models[gv] == nil {
+				continue
+			}
+		} else if changed {
+			models[gv] = gvModels
+			fingerprints[gv] = fingerprint
+		}
+		if models[gv] == nil {
+			continue
+		}
+		if changed {
+			changedGVs[gv] = true
+		}
+
+		// Resolving a schemaID to its model (a full model scan via buildGVKIndex) and fetching its CRD
+		// schema (a live API call) are only needed when this GV's models actually changed - an unchanged
+		// GV's existing schemaToModel/crdSchemas entries (carried forward via the copied maps above) are
+		// still correct. Identifying which schemaIDs and categories this GV's resourceList currently
+		// lists, below, is cheap and always done, so removals are caught even when models didn't change.
+		var gvkToModel map[schema.GroupVersionKind]string
+		if changed {
+			gvkToModel = buildGVKIndex(*models[gv])
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if _, _, ok := splitSubresourceName(apiResource.Name); ok {
+				// Subresources (e.g. "deployments/scale") are indexed in the second pass below, once
+				// every GV's primary kinds have been resolved.
+				continue
+			}
+			schemaID := strings.ToLower(gv.Group) + "." + strings.ToLower(apiResource.Kind)
+			addGVSchemaID(gv, schemaID)
+			reconcileCategories(categories, schemaCategories, schemaID, apiResource.Categories)
+
+			if !changed {
+				continue
+			}
+			gvk := schema.GroupVersionKind{Group: gv.Group, Version: gv.Version, Kind: apiResource.Kind}
+			modelName, ok := gvkToModel[gvk]
+			if !ok {
+				continue
+			}
+			schemaToModel[schemaID] = modelName
+			modelGV[modelName] = gv
+
+			if h.crdClient != nil {
+				if crdSchema, crdErr := fetchCRDSchema(h.crdClient, gv, apiResource); crdErr != nil {
+					errs = append(errs, fmt.Errorf("unable to fetch CRD schema for %s: %w", schemaID, crdErr))
+				} else if crdSchema != nil {
+					crdSchemas[modelName] = crdSchema
+				}
+			}
+		}
+	}
+
+	// Subresources (status, scale, ...) are resolved in a second pass, once every GV in this Refresh
+	// has its primary kinds indexed: a subresource's response type (e.g. autoscaling/v1 Scale) often
+	// lives in a different group than the resource it hangs off of, so it's looked up among every
+	// kind in the owning GV's models (for the v2 fallback, the whole cluster-wide document) by its
+	// declared group/version/Kind, or by Kind alone (see resolveSubresourceModel), rather than being
+	// restricted to the owning resourceList's own group/version.
+	// As above, identifying which subresource schemaIDs are currently listed is always done; only
+	// resolving a new or changed one to its model needs this GV's models to have actually changed.
+	for _, resourceList := range resources {
+		if resourceList == nil {
+			continue
+		}
+		gv, parseErr := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if parseErr != nil || preferredVersions[gv.Group] != gv.Version || models[gv] == nil {
+			continue
+		}
+
+		kindByName := map[string]string{}
+		for _, apiResource := range resourceList.APIResources {
+			if _, _, ok := splitSubresourceName(apiResource.Name); !ok {
+				kindByName[apiResource.Name] = apiResource.Kind
+			}
+		}
+
+		var gvkToModel map[schema.GroupVersionKind]string
+		if changedGVs[gv] {
+			gvkToModel = buildGVKIndex(*models[gv])
+		}
+		for _, apiResource := range resourceList.APIResources {
+			parentName, subresource, ok := splitSubresourceName(apiResource.Name)
+			if !ok {
+				continue
+			}
+			parentKind, ok := kindByName[parentName]
+			if !ok {
+				continue
+			}
+			schemaID := strings.ToLower(gv.Group) + "." + strings.ToLower(parentKind) + "." + subresource
+			addGVSchemaID(gv, schemaID)
+
+			if !changedGVs[gv] {
+				continue
+			}
+			modelName, ok := resolveSubresourceModel(gvkToModel, apiResource.Kind, apiResource.Group, apiResource.Version)
+			if !ok {
+				continue
+			}
+			schemaToModel[schemaID] = modelName
+			modelGV[modelName] = gv
+		}
+	}
+
+	// Prune: a GV observed this cycle may list fewer schemaIDs than it used to (a CRD, resource or
+	// subresource removed from its resourceList - independent of whether its models changed); a GV not
+	// observed at all this cycle - and not exempted by a transient partial-discovery failure - has
+	// disappeared entirely. Either way, anything it previously contributed that it no longer does gets
+	// purged, rather than staying cached forever.
+	for gv := range observedGVs {
+		pruneStaleSchemaIDs(gvSchemaIDs[gv], newGVSchemaIDs[gv], schemaToModel, modelGV, crdSchemas, categories, schemaCategories)
+		gvSchemaIDs[gv] = newGVSchemaIDs[gv]
+	}
+	for gv := range models {
+		if observedGVs[gv] || failedGVs[gv] {
+			continue
+		}
+		pruneStaleSchemaIDs(gvSchemaIDs[gv], nil, schemaToModel, modelGV, crdSchemas, categories, schemaCategories)
+		delete(models, gv)
+		delete(fingerprints, gv)
+		delete(gvSchemaIDs, gv)
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.v2Models = v2Models
+	h.v2DocHash = v2DocHash
+	h.models = models
+	h.schemaToModel = schemaToModel
+	h.modelGV = modelGV
+	h.fingerprints = fingerprints
+	h.crdSchemas = crdSchemas
+	h.categories = categories
+	h.schemaCategories = schemaCategories
+	h.gvSchemaIDs = gvSchemaIDs
+
+	if partial {
+		errs = append(errs, groupDiscoveryErr)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors encountered refreshing schema definitions: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// resolveGVModels returns the models to use for gv: the per-GV OpenAPI v3 document if discovery
+// advertised one and its fingerprint changed, otherwise the cluster-wide OpenAPI v2 models.
+// changed is false when the resolved fingerprint matches previousFingerprint, meaning the caller
+// already has the right models cached and doesn't need to do anything.
+func resolveGVModels(gv schema.GroupVersion, v3Paths map[schema.GroupVersion]openapi.GroupVersion, v2Models *proto.Models, v2DocHash string, previousFingerprint string) (gvModels *proto.Models, fingerprint string, changed bool, err error) {
+	path, ok := v3Paths[gv]
+	if !ok {
+		fingerprint = "v2:" + v2DocHash
+		return v2Models, fingerprint, fingerprint != previousFingerprint, nil
+	}
+
+	raw, err := path.Schema("application/json")
+	if err != nil {
+		return nil, "", false, fmt.Errorf("unable to fetch openapi v3 schema: %w", err)
+	}
+	fingerprint = "v3:" + hashBytes(raw)
+	if fingerprint == previousFingerprint {
+		return nil, fingerprint, false, nil
+	}
+	doc, err := openapi_v3.ParseDocument(raw)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("unable to parse openapi v3 schema: %w", err)
+	}
+	v3Models, err := proto.NewOpenAPIV3Data(doc)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("unable to parse openapi v3 schema: %w", err)
+	}
+	return &v3Models, fingerprint, true, nil
+}
+
+// hashV2Document fingerprints doc's definitions so Refresh can tell whether the cluster-wide v2
+// document changed since it was last parsed. It walks each definition's schema content (description,
+// type, format, properties, required fields, refs, items, additionalProperties, allOf, vendor
+// extensions) rather than just its top-level description - most
+// real-world schema changes (a new or changed field, a newly required field, a changed type) never
+// touch the description, and plenty of definitions carry no description at all.
+func hashV2Document(doc *openapi_v2.Document) string {
+	sum := fnv.New64a()
+	if doc != nil && doc.GetDefinitions() != nil {
+		for _, pair := range doc.GetDefinitions().GetAdditionalProperties() {
+			fmt.Fprintf(sum, "%s:", pair.GetName())
+			hashV2Schema(sum, pair.GetValue())
+			fmt.Fprint(sum, "|")
+		}
+	}
+	return fmt.Sprintf("%x", sum.Sum64())
+}
+
+// hashV2Schema writes a deterministic representation of s's content into sum, recursing into
+// properties, items, additionalProperties and allOf so a change to any of the parts listed on
+// hashV2Document - not just the description - changes the fingerprint.
+func hashV2Schema(sum hash.Hash64, s *openapi_v2.Schema) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(sum, "ref=%s;format=%s;type=%s;required=%s;description=%s;", s.GetRef(), s.GetFormat(),
+		strings.Join(s.GetType().GetValue(), ","), strings.Join(s.GetRequired(), ","), s.GetDescription())
+	for _, ext := range s.GetVendorExtension() {
+		fmt.Fprintf(sum, "ext:%s=%s;", ext.GetName(), ext.GetValue().GetYaml())
+	}
+	if additional := s.GetAdditionalProperties().GetSchema(); additional != nil {
+		fmt.Fprint(sum, "additionalProperties:")
+		hashV2Schema(sum, additional)
+	}
+	for _, prop := range s.GetProperties().GetAdditionalProperties() {
+		fmt.Fprintf(sum, "prop:%s:", prop.GetName())
+		hashV2Schema(sum, prop.GetValue())
+	}
+	for _, item := range s.GetItems().GetSchema() {
+		hashV2Schema(sum, item)
+	}
+	for _, sub := range s.GetAllOf() {
+		hashV2Schema(sum, sub)
+	}
+}
+
+// hashBytes fingerprints an arbitrary OpenAPI v3 document body.
+func hashBytes(raw []byte) string {
+	sum := fnv.New64a()
+	sum.Write(raw)
+	return fmt.Sprintf("%x", sum.Sum64())
+}
+
+func copyModelMap(in map[schema.GroupVersion]*proto.Models) map[schema.GroupVersion]*proto.Models {
+	out := make(map[schema.GroupVersion]*proto.Models, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func copyGVMap(in map[string]schema.GroupVersion) map[string]schema.GroupVersion {
+	out := make(map[string]schema.GroupVersion, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func copyFingerprintMap(in map[schema.GroupVersion]string) map[schema.GroupVersion]string {
+	out := make(map[schema.GroupVersion]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func copyCRDSchemaMap(in map[string]*apiextv1.JSONSchemaProps) map[string]*apiextv1.JSONSchemaProps {
+	out := make(map[string]*apiextv1.JSONSchemaProps, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func copyCategoryMap(in map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(in))
+	for k, v := range in {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// copyGVSchemaIDsMap shallow-copies in: the per-GV schemaID sets it holds are only ever replaced
+// wholesale (never mutated in place) by a later Refresh, so sharing them between calls is safe.
+func copyGVSchemaIDsMap(in map[schema.GroupVersion]map[string]bool) map[schema.GroupVersion]map[string]bool {
+	out := make(map[schema.GroupVersion]map[string]bool, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// addToCategory records schemaID under category, without adding a duplicate entry if Refresh has
+// already seen this resource in a previous call.
+func addToCategory(categories map[string][]string, category, schemaID string) {
+	for _, existing := range categories[category] {
+		if existing == schemaID {
+			return
+		}
+	}
+	categories[category] = append(categories[category], schemaID)
+}
+
+// removeSchemaIDFromCategory strips schemaID out of category's membership list, deleting the category
+// entirely once it has no members left.
+func removeSchemaIDFromCategory(categories map[string][]string, category, schemaID string) {
+	schemaIDs := categories[category]
+	for i, existing := range schemaIDs {
+		if existing == schemaID {
+			schemaIDs = append(schemaIDs[:i], schemaIDs[i+1:]...)
+			break
+		}
+	}
+	if len(schemaIDs) == 0 {
+		delete(categories, category)
+	} else {
+		categories[category] = schemaIDs
+	}
+}
+
+// removeFromCategories strips schemaID out of every category it was recorded under, deleting the
+// category entirely once it has no members left.
+func removeFromCategories(categories map[string][]string, schemaID string) {
+	for category := range categories {
+		removeSchemaIDFromCategory(categories, category, schemaID)
+	}
+}
+
+// reconcileCategories updates categories and schemaCategories so that schemaID's recorded category
+// membership exactly matches current: it's removed from any category schemaCategories previously
+// recorded for it that current no longer lists, and added to every category in current (a no-op for
+// ones already present). This catches a resource dropping a category in its discovery entry even
+// though the resource itself still exists, which schemaToModel/gvSchemaIDs tracking alone wouldn't.
+func reconcileCategories(categories map[string][]string, schemaCategories map[string][]string, schemaID string, current []string) {
+	currentSet := make(map[string]bool, len(current))
+	for _, category := range current {
+		currentSet[category] = true
+	}
+	for _, category := range schemaCategories[schemaID] {
+		if !currentSet[category] {
+			removeSchemaIDFromCategory(categories, category, schemaID)
+		}
+	}
+	for _, category := range current {
+		addToCategory(categories, category, schemaID)
+	}
+	if len(current) == 0 {
+		delete(schemaCategories, schemaID)
+	} else {
+		schemaCategories[schemaID] = append([]string(nil), current...)
+	}
+}
+
+// modelStillReferenced reports whether any schemaID in schemaToModel still resolves to modelName -
+// used before pruning modelGV/crdSchemas for a model that more than one schemaID can point to (e.g.
+// two different resources' "/scale" subresource sharing the same Scale model).
+func modelStillReferenced(schemaToModel map[string]string, modelName string) bool {
+	for _, name := range schemaToModel {
+		if name == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneStaleSchemaIDs removes every schemaID present in old but not in current from schemaToModel,
+// categories, schemaCategories and - once nothing else references the model - modelGV and crdSchemas.
+// old/current are both nil-safe: a nil current (used when a GV has disappeared entirely) prunes
+// everything in old.
+func pruneStaleSchemaIDs(old, current map[string]bool, schemaToModel map[string]string, modelGV map[string]schema.GroupVersion, crdSchemas map[string]*apiextv1.JSONSchemaProps, categories map[string][]string, schemaCategories map[string][]string) {
+	for schemaID := range old {
+		if current[schemaID] {
+			continue
+		}
+		delete(schemaCategories, schemaID)
+		removeFromCategories(categories, schemaID)
+		modelName, ok := schemaToModel[schemaID]
+		if !ok {
+			continue
+		}
+		delete(schemaToModel, schemaID)
+		if !modelStillReferenced(schemaToModel, modelName) {
+			delete(modelGV, modelName)
+			delete(crdSchemas, modelName)
+		}
+	}
+}
+
+// fetchCRDSchema fetches the CustomResourceDefinition backing gv/apiResource (if any) and returns
+// the openAPIV3Schema it authored for gv.Version. A nil result (with no error) means apiResource
+// isn't CRD-backed, or the CRD doesn't publish a schema for this version - callers should keep
+// relying on the aggregated OpenAPI document alone in that case.
+func fetchCRDSchema(crdClient dynamic.Interface, gv schema.GroupVersion, apiResource metav1.APIResource) (*apiextv1.JSONSchemaProps, error) {
+	crdName := apiResource.Name + "." + gv.Group
+	obj, err := crdClient.Resource(crdGroupVersionResource).Get(context.Background(), crdName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var crd apiextv1.CustomResourceDefinition
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &crd); err != nil {
+		return nil, fmt.Errorf("unable to convert CRD %s: %w", crdName, err)
+	}
+	for _, version := range crd.Spec.Versions {
+		if version.Name == gv.Version && version.Schema != nil {
+			return version.Schema.OpenAPIV3Schema, nil
+		}
+	}
+	return nil, nil
+}
+
+// fetchOpenAPIV3Paths lists the per-GroupVersion OpenAPI v3 paths the apiserver advertises. It
+// returns an empty map (never an error) when the client doesn't support OpenAPI v3 at all, so
+// callers can unconditionally fall back to the v2 document.
+func fetchOpenAPIV3Paths(client discovery.DiscoveryInterface) map[schema.GroupVersion]openapi.GroupVersion {
+	result := map[schema.GroupVersion]openapi.GroupVersion{}
+	v3Client := client.OpenAPIV3()
+	if v3Client == nil {
+		return result
+	}
+	paths, err := v3Client.Paths()
+	if err != nil {
+		return result
+	}
+	for path, gvPath := range paths {
+		gv, ok := groupVersionForOpenAPIV3Path(path)
+		if !ok {
+			continue
+		}
+		result[gv] = gvPath
+	}
+	return result
+}
+
+// groupVersionForOpenAPIV3Path translates an OpenAPI v3 path ("api/v1", "apis/apps/v1", ...) into
+// the GroupVersion it describes.
+func groupVersionForOpenAPIV3Path(path string) (schema.GroupVersion, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "api":
+		return schema.GroupVersion{Version: parts[1]}, true
+	case len(parts) == 3 && parts[0] == "apis":
+		return schema.GroupVersion{Group: parts[1], Version: parts[2]}, true
+	default:
+		return schema.GroupVersion{}, false
+	}
+}
+
+// buildGVKIndex scans every model exposed by models and indexes it by the GroupVersionKind(s) its
+// x-kubernetes-group-version-kind extension claims, so Refresh can resolve a discovered APIResource
+// straight to the model name that describes it.
+func buildGVKIndex(models proto.Models) map[schema.GroupVersionKind]string {
+	index := map[schema.GroupVersionKind]string{}
+	for _, name := range models.ListModels() {
+		kind, ok := models.LookupModel(name).(*proto.Kind)
+		if !ok {
+			continue
+		}
+		rawExt, ok := kind.Extensions["x-kubernetes-group-version-kind"]
+		if !ok {
+			continue
+		}
+		entries, ok := rawExt.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, rawEntry := range entries {
+			entry := normalizeExtensionMap(rawEntry)
+			if entry == nil {
+				continue
+			}
+			group, _ := entry["group"].(string)
+			version, _ := entry["version"].(string)
+			entryKind, _ := entry["kind"].(string)
+			index[schema.GroupVersionKind{Group: group, Version: version, Kind: entryKind}] = name
+		}
+	}
+	return index
+}
+
+// resolveSubresourceModel looks up the model describing a subresource's response type (e.g. the
+// Scale type backing a "/scale" subresource) within gvkToModel. Kind names are reused across groups
+// (autoscaling/v1 Scale vs. autoscaling/v2 Scale, or the same Status/Patch/WatchEvent kind defined by
+// several groups), so collapsing straight to Kind resolves ambiguously. When apiResource declares a
+// group/version that actually matches one of the candidates, that exact GVK wins; otherwise (no
+// declared group/version, or one that doesn't identify any candidate here - e.g. it simply repeats
+// the owning resource's own group/version) candidates are narrowed to Kind alone and ties are broken
+// deterministically by picking the lowest (group, version) pair, rather than depending on
+// models.ListModels() iteration order.
+func resolveSubresourceModel(gvkToModel map[schema.GroupVersionKind]string, kind, group, version string) (string, bool) {
+	if group != "" || version != "" {
+		if modelName, ok := gvkToModel[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}]; ok {
+			return modelName, true
+		}
+	}
+
+	var best schema.GroupVersionKind
+	var bestModel string
+	found := false
+	for gvk, modelName := range gvkToModel {
+		if gvk.Kind != kind {
+			continue
+		}
+		if !found || gvk.Group < best.Group || (gvk.Group == best.Group && gvk.Version < best.Version) {
+			best, bestModel, found = gvk, modelName, true
+		}
+	}
+	return bestModel, found
+}
+
+// splitSubresourceName splits a discovery APIResource name (e.g. "deployments/scale") or a
+// byIDHandler request name (e.g. "apps.deployment/scale") on its first "/", returning ok=false if
+// name doesn't describe a subresource.
+func splitSubresourceName(name string) (parent, subresource string, ok bool) {
+	idx := strings.IndexByte(name, '/')
+	if idx == -1 {
+		return "", "", false
+	}
+	return name[:idx], name[idx+1:], true
+}
+
+// normalizeExtensionMap coerces the two shapes an OpenAPI extension value tends to come back as
+// (map[string]interface{} from JSON, map[interface{}]interface{} from YAML) into the former.
+func normalizeExtensionMap(raw interface{}) map[string]interface{} {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		return v
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			if k, ok := key.(string); ok {
+				out[k] = val
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -10,14 +10,20 @@ import (
 	wschemas "github.com/rancher/wrangler/v2/pkg/schemas"
 	"github.com/stretchr/testify/require"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/openapi"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/kube-openapi/pkg/util/proto"
 )
 
+var defaultGV = schema.GroupVersion{Group: "management.cattle.io", Version: "v2"}
+var appsGV = schema.GroupVersion{Group: "apps", Version: "v1"}
+
 func TestRefresh(t *testing.T) {
 	defaultDocument, err := openapi_v2.ParseDocument([]byte(openapi_raw))
 	require.NoError(t, err)
@@ -26,20 +32,25 @@ func TestRefresh(t *testing.T) {
 	defaultSchemaToModel := map[string]string{
 		"management.cattle.io.globalrole": "io.cattle.management.v2.GlobalRole",
 	}
+	defaultModelGV := map[string]schema.GroupVersion{
+		"io.cattle.management.v2.GlobalRole": defaultGV,
+	}
 	tests := []struct {
 		name                     string
 		openapiError             error
 		serverGroupsResourcesErr error
 		useBadOpenApiDoc         bool
 		unparseableGV            bool
-		wantModels               *proto.Models
+		wantModels               map[schema.GroupVersion]*proto.Models
 		wantSchemaToModel        map[string]string
+		wantModelGV              map[string]schema.GroupVersion
 		wantError                bool
 	}{
 		{
 			name:              "success",
-			wantModels:        &defaultModels,
+			wantModels:        map[schema.GroupVersion]*proto.Models{defaultGV: &defaultModels},
 			wantSchemaToModel: defaultSchemaToModel,
+			wantModelGV:       defaultModelGV,
 		},
 		{
 			name:         "error - openapi doc unavailable",
@@ -54,7 +65,6 @@ func TestRefresh(t *testing.T) {
 		{
 			name:                     "error - unable to retrieve groups and resources",
 			serverGroupsResourcesErr: fmt.Errorf("server not available"),
-			wantModels:               &defaultModels,
 			wantError:                true,
 		},
 		{
@@ -67,15 +77,17 @@ func TestRefresh(t *testing.T) {
 					}: fmt.Errorf("some group error"),
 				},
 			},
-			wantModels:        &defaultModels,
+			wantModels:        map[schema.GroupVersion]*proto.Models{defaultGV: &defaultModels},
 			wantSchemaToModel: defaultSchemaToModel,
+			wantModelGV:       defaultModelGV,
 			wantError:         true,
 		},
 		{
 			name:              "error - unparesable gv",
 			unparseableGV:     true,
-			wantModels:        &defaultModels,
+			wantModels:        map[schema.GroupVersion]*proto.Models{defaultGV: &defaultModels},
 			wantSchemaToModel: defaultSchemaToModel,
+			wantModelGV:       defaultModelGV,
 			wantError:         true,
 		},
 	}
@@ -109,11 +121,352 @@ func TestRefresh(t *testing.T) {
 			}
 			require.Equal(t, test.wantModels, handler.models)
 			require.Equal(t, test.wantSchemaToModel, handler.schemaToModel)
+			require.Equal(t, test.wantModelGV, handler.modelGV)
 		})
 
 	}
 }
 
+// TestRefresh_PartialFailureKeepsStaleModels is modeled on the "error - unable to retrieve all
+// groups and resources" case above, but exercises a second Refresh call in which the previously
+// healthy other.cattle.io/v1 group now fails discovery. Its last-known-good models must remain in
+// place (and queryable via byIDHandler) rather than being dropped.
+func TestRefresh_PartialFailureKeepsStaleModels(t *testing.T) {
+	otherGV := schema.GroupVersion{Group: "other.cattle.io", Version: "v1"}
+	otherResourceList := &metav1.APIResourceList{
+		GroupVersion: otherGV.String(),
+		APIResources: []metav1.APIResource{
+			{
+				Group:   otherGV.Group,
+				Kind:    "OtherThing",
+				Version: otherGV.Version,
+			},
+		},
+	}
+
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+	client.Groups = append(client.Groups, &metav1.APIGroup{
+		Name:             otherGV.Group,
+		PreferredVersion: metav1.GroupVersionForDiscovery{Version: otherGV.Version},
+	})
+	client.Resources = append(client.Resources, otherResourceList)
+
+	handler := SchemaDefinitionHandler{client: client}
+	require.NoError(t, handler.Refresh())
+	require.Equal(t, "io.cattle.other.v1.OtherThing", handler.schemaToModel["other.cattle.io.otherthing"])
+	require.NotNil(t, handler.models[otherGV])
+
+	staleModels := handler.models[otherGV]
+
+	// Simulate the apiserver dropping other.cattle.io/v1 from discovery on the next refresh.
+	client.Resources = client.Resources[:len(client.Resources)-1]
+	client.GroupResourcesErr = &discovery.ErrGroupDiscoveryFailed{
+		Groups: map[schema.GroupVersion]error{otherGV: fmt.Errorf("other.cattle.io temporarily unavailable")},
+	}
+
+	err = handler.Refresh()
+	require.Error(t, err)
+
+	require.Equal(t, "io.cattle.management.v2.GlobalRole", handler.schemaToModel["management.cattle.io.globalrole"])
+	require.Equal(t, "io.cattle.other.v1.OtherThing", handler.schemaToModel["other.cattle.io.otherthing"])
+	require.Same(t, staleModels, handler.models[otherGV])
+
+	response, err := handler.byIDHandler(&types.APIRequest{
+		Schemas: types.EmptyAPISchemas(),
+		Name:    "other.cattle.io.otherthing",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "io.cattle.other.v1.OtherThing", response.Object.(schemaDefinition).DefinitionType)
+}
+
+// TestRefresh_PrunesRemovedEntries asserts that Refresh cleans up entries that have genuinely
+// disappeared from discovery - both a whole GroupVersion going away, and a single resource/category
+// membership dropping out of a GV that's still otherwise present - rather than leaving them cached
+// forever. This is distinct from TestRefresh_PartialFailureKeepsStaleModels, where the same absence is
+// caused by a transient discovery error and must NOT be pruned.
+func TestRefresh_PrunesRemovedEntries(t *testing.T) {
+	otherGV := schema.GroupVersion{Group: "other.cattle.io", Version: "v1"}
+	otherResourceList := &metav1.APIResourceList{
+		GroupVersion: otherGV.String(),
+		APIResources: []metav1.APIResource{
+			{Group: otherGV.Group, Version: otherGV.Version, Kind: "OtherThing", Name: "otherthings", Categories: []string{"all"}},
+		},
+	}
+
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+	client.Groups = append(client.Groups, &metav1.APIGroup{
+		Name:             otherGV.Group,
+		PreferredVersion: metav1.GroupVersionForDiscovery{Version: otherGV.Version},
+	})
+	client.Resources = append(client.Resources, otherResourceList)
+	client.Groups = append(client.Groups, &metav1.APIGroup{
+		Name:             appsGV.Group,
+		PreferredVersion: metav1.GroupVersionForDiscovery{Version: appsGV.Version},
+	})
+	client.Resources = append(client.Resources, &metav1.APIResourceList{
+		GroupVersion: appsGV.String(),
+		APIResources: []metav1.APIResource{
+			{Group: appsGV.Group, Version: appsGV.Version, Kind: "Deployment", Name: "deployments", Categories: []string{"all"}},
+			{Group: appsGV.Group, Version: appsGV.Version, Kind: "Scale", Name: "deployments/scale"},
+		},
+	})
+
+	handler := SchemaDefinitionHandler{client: client}
+	require.NoError(t, handler.Refresh())
+	require.Equal(t, "io.cattle.other.v1.OtherThing", handler.schemaToModel["other.cattle.io.otherthing"])
+	require.Equal(t, "io.k8s.api.autoscaling.v1.Scale", handler.schemaToModel["apps.deployment.scale"])
+	require.ElementsMatch(t, []string{"management.cattle.io.globalrole", "other.cattle.io.otherthing", "apps.deployment"}, handler.categories["all"])
+
+	// other.cattle.io/v1 is retired entirely, apps/v1 drops its "/scale" subresource, and Deployment
+	// stops listing the "all" category (while remaining a known resource). None of these are reported
+	// as discovery errors, so all three should be pruned - note this doesn't change the OpenAPI v2
+	// document at all, only the discovery resource lists, so apps/v1's models are unchanged.
+	client.Resources = []*metav1.APIResourceList{
+		client.Resources[0], client.Resources[1],
+		{
+			GroupVersion: appsGV.String(),
+			APIResources: []metav1.APIResource{
+				{Group: appsGV.Group, Version: appsGV.Version, Kind: "Deployment", Name: "deployments"},
+			},
+		},
+	}
+
+	require.NoError(t, handler.Refresh())
+
+	require.NotContains(t, handler.schemaToModel, "other.cattle.io.otherthing")
+	require.NotContains(t, handler.modelGV, "io.cattle.other.v1.OtherThing")
+	require.Nil(t, handler.models[otherGV])
+	require.NotContains(t, handler.fingerprints, otherGV)
+
+	require.NotContains(t, handler.schemaToModel, "apps.deployment.scale")
+	require.Equal(t, "io.k8s.api.apps.v1.Deployment", handler.schemaToModel["apps.deployment"])
+	require.ElementsMatch(t, []string{"management.cattle.io.globalrole"}, handler.categories["all"])
+}
+
+// TestRefresh_MergesCRDSchema asserts that, when a CRD client is configured, a CRD's own
+// openAPIV3Schema is merged onto the fields the aggregated OpenAPI document already described.
+func TestRefresh_MergesCRDSchema(t *testing.T) {
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+
+	crdClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		crdGroupVersionResource: "CustomResourceDefinitionList",
+	}, globalRoleCRDFixture())
+
+	handler := SchemaDefinitionHandler{client: client, crdClient: crdClient}
+	require.NoError(t, handler.Refresh())
+
+	response, err := handler.byIDHandler(&types.APIRequest{
+		Schemas: types.EmptyAPISchemas(),
+		Name:    "management.cattle.io.globalrole",
+	})
+	require.NoError(t, err)
+
+	spec := response.Object.(schemaDefinition).Definitions["io.cattle.management.v2.GlobalRole.spec"]
+	require.Equal(t, "^[a-z0-9-]+$", spec.ResourceFields["clusterName"].Pattern)
+	require.Equal(t, true, spec.ResourceFields["notRequired"].Default)
+	require.Equal(t, true, spec.ResourceFields["notRequired"].PreserveUnknownFields)
+	require.Equal(t, []string{"Admin", "Viewer", "Editor"}, spec.ResourceFields["displayName"].Enum)
+	newField := spec.ResourceFields["newField"]
+	require.NotNil(t, newField.Minimum)
+	require.Equal(t, float64(1), *newField.Minimum)
+	require.NotNil(t, newField.Maximum)
+	require.Equal(t, float64(100), *newField.Maximum)
+	require.True(t, newField.Nullable)
+	require.True(t, newField.IntOrString)
+}
+
+// globalRoleCRDFixture is the CustomResourceDefinition backing management.cattle.io/v2 GlobalRole,
+// authoring a handful of extras the aggregated OpenAPI document (testdata_test.go's openapi_raw)
+// doesn't carry: a pattern on clusterName, a default and x-kubernetes-preserve-unknown-fields on
+// notRequired, an enum on displayName, and minimum/maximum/nullable/x-kubernetes-int-or-string on
+// newField - enough of mergeCRDField's conversions to catch a regression in any one of them.
+func globalRoleCRDFixture() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata": map[string]interface{}{
+				"name": "globalroles.management.cattle.io",
+			},
+			"spec": map[string]interface{}{
+				"group": "management.cattle.io",
+				"versions": []interface{}{
+					map[string]interface{}{
+						"name": "v2",
+						"schema": map[string]interface{}{
+							"openAPIV3Schema": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"spec": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"clusterName": map[string]interface{}{
+												"type":    "string",
+												"pattern": "^[a-z0-9-]+$",
+											},
+											"displayName": map[string]interface{}{
+												"type": "string",
+												"enum": []interface{}{"Admin", "Viewer", "Editor"},
+											},
+											"newField": map[string]interface{}{
+												"type":                       "string",
+												"minimum":                    int64(1),
+												"maximum":                    int64(100),
+												"nullable":                   true,
+												"x-kubernetes-int-or-string": true,
+											},
+											"notRequired": map[string]interface{}{
+												"type":                                  "boolean",
+												"default":                               true,
+												"x-kubernetes-preserve-unknown-fields": true,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// TestRefresh_BuildsCategories asserts that Refresh indexes every APIResource's discovery categories
+// so byIDHandler can later serve a synthesized definition for the category itself.
+func TestRefresh_BuildsCategories(t *testing.T) {
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+
+	handler := SchemaDefinitionHandler{client: client}
+	require.NoError(t, handler.Refresh())
+	require.Equal(t, []string{"management.cattle.io.globalrole"}, handler.categories["all"])
+
+	// A second Refresh shouldn't duplicate the entry.
+	require.NoError(t, handler.Refresh())
+	require.Equal(t, []string{"management.cattle.io.globalrole"}, handler.categories["all"])
+}
+
+// TestRefresh_IndexesSubresourceSchemas asserts that Refresh records a subresource's schema (here,
+// the /scale subresource discovery reports for Deployment) under the composite key byIDHandler
+// expects, and that it resolves to the subresource's own response type rather than the parent's.
+func TestRefresh_IndexesSubresourceSchemas(t *testing.T) {
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+	client.Groups = append(client.Groups, &metav1.APIGroup{
+		Name:             appsGV.Group,
+		PreferredVersion: metav1.GroupVersionForDiscovery{Version: appsGV.Version},
+	})
+	client.Resources = append(client.Resources, &metav1.APIResourceList{
+		GroupVersion: appsGV.String(),
+		APIResources: []metav1.APIResource{
+			{Group: appsGV.Group, Version: appsGV.Version, Kind: "Deployment", Name: "deployments"},
+			{Group: appsGV.Group, Version: appsGV.Version, Kind: "Scale", Name: "deployments/scale"},
+		},
+	})
+
+	handler := SchemaDefinitionHandler{client: client}
+	require.NoError(t, handler.Refresh())
+
+	require.Equal(t, "io.k8s.api.autoscaling.v1.Scale", handler.schemaToModel["apps.deployment.scale"])
+	require.Equal(t, appsGV, handler.modelGV["io.k8s.api.autoscaling.v1.Scale"])
+
+	response, err := handler.byIDHandler(&types.APIRequest{
+		Schemas: types.EmptyAPISchemas(),
+		Name:    "apps.deployment/scale",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "io.k8s.api.autoscaling.v1.Scale", response.Object.(schemaDefinition).DefinitionType)
+}
+
+// TestRefresh_OpenAPIV3 asserts that Refresh prefers a per-GV OpenAPI v3 document over the v2
+// fallback when discovery advertises one, that re-fetching an unchanged document is a no-op (the
+// cached models are reused rather than re-parsed), and that a changed document is re-parsed and
+// picked up.
+func TestRefresh_OpenAPIV3(t *testing.T) {
+	otherV3GV := schema.GroupVersion{Group: "other.cattle.io", Version: "v3"}
+	otherResourceList := &metav1.APIResourceList{
+		GroupVersion: otherV3GV.String(),
+		APIResources: []metav1.APIResource{
+			{Group: otherV3GV.Group, Version: otherV3GV.Version, Kind: "ThirdThing", Name: "thirdthings"},
+		},
+	}
+
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+	client.Groups = append(client.Groups, &metav1.APIGroup{
+		Name:             otherV3GV.Group,
+		PreferredVersion: metav1.GroupVersionForDiscovery{Version: otherV3GV.Version},
+	})
+	client.Resources = append(client.Resources, otherResourceList)
+	client.V3Paths = map[string]openapi.GroupVersion{
+		"apis/other.cattle.io/v3": &fakeOpenAPIV3GroupVersion{raw: []byte(openapiV3Raw)},
+	}
+
+	handler := SchemaDefinitionHandler{client: client}
+	require.NoError(t, handler.Refresh())
+	require.Equal(t, "io.cattle.other.v3.ThirdThing", handler.schemaToModel["other.cattle.io.thirdthing"])
+
+	response, err := handler.byIDHandler(&types.APIRequest{
+		Schemas: types.EmptyAPISchemas(),
+		Name:    "other.cattle.io.thirdthing",
+	})
+	require.NoError(t, err)
+	definition := response.Object.(schemaDefinition).Definitions["io.cattle.other.v3.ThirdThing"]
+	require.NotContains(t, definition.ResourceFields, "newField")
+
+	v3Models := handler.models[otherV3GV]
+	require.NoError(t, handler.Refresh())
+	require.Same(t, v3Models, handler.models[otherV3GV], "an unchanged v3 document should not be re-parsed")
+
+	client.V3Paths["apis/other.cattle.io/v3"] = &fakeOpenAPIV3GroupVersion{raw: []byte(openapiV3RawUpdated)}
+	require.NoError(t, handler.Refresh())
+	require.NotSame(t, v3Models, handler.models[otherV3GV], "a changed v3 document should be re-parsed")
+
+	response, err = handler.byIDHandler(&types.APIRequest{
+		Schemas: types.EmptyAPISchemas(),
+		Name:    "other.cattle.io.thirdthing",
+	})
+	require.NoError(t, err)
+	definition = response.Object.(schemaDefinition).Definitions["io.cattle.other.v3.ThirdThing"]
+	require.Contains(t, definition.ResourceFields, "newField")
+}
+
+// TestRefresh_DisambiguatesSubresourceKind asserts that, when more than one GroupVersion defines a
+// Kind with the same name (here, "Scale" in both autoscaling/v1 and autoscaling/v2beta2), a
+// subresource's own declared group/version picks the right one, and that omitting it falls back to a
+// deterministic tie-break rather than whichever model happens to win ListModels() iteration order.
+func TestRefresh_DisambiguatesSubresourceKind(t *testing.T) {
+	client, err := buildDefaultDiscovery()
+	require.NoError(t, err)
+	client.Groups = append(client.Groups, &metav1.APIGroup{
+		Name:             appsGV.Group,
+		PreferredVersion: metav1.GroupVersionForDiscovery{Version: appsGV.Version},
+	})
+	client.Resources = append(client.Resources, &metav1.APIResourceList{
+		GroupVersion: appsGV.String(),
+		APIResources: []metav1.APIResource{
+			{Group: appsGV.Group, Version: appsGV.Version, Kind: "Deployment", Name: "deployments"},
+			{Group: "autoscaling", Version: "v2beta2", Kind: "Scale", Name: "deployments/scale"},
+			{Group: appsGV.Group, Version: appsGV.Version, Kind: "ReplicaSet", Name: "replicasets"},
+			{Kind: "Scale", Name: "replicasets/scale"},
+		},
+	})
+
+	handler := SchemaDefinitionHandler{client: client}
+	require.NoError(t, handler.Refresh())
+
+	// An explicit, matching group/version wins over the Kind-only tie-break.
+	require.Equal(t, "io.k8s.api.autoscaling.v2beta2.Scale", handler.schemaToModel["apps.deployment.scale"])
+
+	// No group/version declared: falls back to the lowest (group, version) pair - autoscaling/v1
+	// sorts before autoscaling/v2beta2 - rather than an arbitrary ListModels() iteration order.
+	require.Equal(t, "io.k8s.api.autoscaling.v1.Scale", handler.schemaToModel["apps.replicaset.scale"])
+}
+
 func Test_byID(t *testing.T) {
 	defaultDocument, err := openapi_v2.ParseDocument([]byte(openapi_raw))
 	require.NoError(t, err)
@@ -122,6 +475,11 @@ func Test_byID(t *testing.T) {
 	defaultSchemaToModel := map[string]string{
 		"management.cattle.io.globalrole": "io.cattle.management.v2.GlobalRole",
 	}
+	defaultModelGV := map[string]schema.GroupVersion{
+		"io.cattle.management.v2.GlobalRole": defaultGV,
+		"io.management.cattle.NotAKind":      defaultGV,
+	}
+	defaultModelMap := map[schema.GroupVersion]*proto.Models{defaultGV: &defaultModels}
 	schemas := types.EmptyAPISchemas()
 	addBaseSchema := func(names ...string) {
 		for _, name := range names {
@@ -144,8 +502,10 @@ func Test_byID(t *testing.T) {
 	tests := []struct {
 		name          string
 		schemaName    string
-		models        *proto.Models
+		models        map[schema.GroupVersion]*proto.Models
 		schemaToModel map[string]string
+		modelGV       map[string]schema.GroupVersion
+		categories    map[string][]string
 		wantObject    *types.APIObject
 		wantError     bool
 		wantErrorCode *int
@@ -153,8 +513,9 @@ func Test_byID(t *testing.T) {
 		{
 			name:          "global role definition",
 			schemaName:    "management.cattle.io.globalrole",
-			models:        &defaultModels,
+			models:        defaultModelMap,
 			schemaToModel: defaultSchemaToModel,
+			modelGV:       defaultModelGV,
 			wantObject: &types.APIObject{
 				ID:   "management.cattle.io.globalrole",
 				Type: "schemaDefinition",
@@ -229,8 +590,9 @@ func Test_byID(t *testing.T) {
 		{
 			name:          "missing definition",
 			schemaName:    "management.cattle.io.cluster",
-			models:        &defaultModels,
+			models:        defaultModelMap,
 			schemaToModel: defaultSchemaToModel,
+			modelGV:       defaultModelGV,
 			wantError:     true,
 			wantErrorCode: intPtr(404),
 		},
@@ -243,21 +605,197 @@ func Test_byID(t *testing.T) {
 		{
 			name:          "has schema, missing from model",
 			schemaName:    "management.cattle.io.missingfrommodel",
-			models:        &defaultModels,
+			models:        defaultModelMap,
 			schemaToModel: defaultSchemaToModel,
+			modelGV:       defaultModelGV,
 			wantError:     true,
 			wantErrorCode: intPtr(503),
 		},
 		{
 			name:       "has schema, model is not a kind",
 			schemaName: "management.cattle.io.notakind",
-			models:     &defaultModels,
+			models:     defaultModelMap,
 			schemaToModel: map[string]string{
 				"management.cattle.io.notakind": "io.management.cattle.NotAKind",
 			},
+			modelGV:       defaultModelGV,
 			wantError:     true,
 			wantErrorCode: intPtr(500),
 		},
+		{
+			name:          "category definition",
+			schemaName:    "all",
+			models:        defaultModelMap,
+			schemaToModel: defaultSchemaToModel,
+			modelGV:       defaultModelGV,
+			categories:    map[string][]string{"all": {"management.cattle.io.globalrole"}},
+			wantObject: &types.APIObject{
+				ID:   "all",
+				Type: "schemaDefinition",
+				Object: schemaDefinition{
+					DefinitionType: "io.cattle.management.v2.GlobalRole",
+					Definitions: map[string]definition{
+						"io.cattle.management.v2.GlobalRole": {
+							ResourceFields: map[string]definitionField{
+								"apiVersion": {
+									Type:        "string",
+									Description: "The APIVersion of this resource",
+								},
+								"kind": {
+									Type:        "string",
+									Description: "The kind",
+								},
+								"metadata": {
+									Type:        "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+									Description: "The metadata",
+								},
+								"spec": {
+									Type: "io.cattle.management.v2.GlobalRole.spec", Description: "The spec for the project",
+								},
+							},
+							Type:        "io.cattle.management.v2.GlobalRole",
+							Description: "A Global Role V2 provides Global Permissions in Rancher",
+						},
+						"io.cattle.management.v2.GlobalRole.spec": {
+							ResourceFields: map[string]definitionField{
+								"clusterName": {
+									Type:        "string",
+									Description: "The name of the cluster",
+									Required:    true,
+								},
+								"displayName": {
+									Type:        "string",
+									Description: "The UI readable name",
+									Required:    true,
+								},
+								"newField": {
+									Type:        "string",
+									Description: "A new field not present in v1",
+								},
+								"notRequired": {
+									Type:        "boolean",
+									Description: "Some field that isn't required",
+								},
+							},
+							Type:        "io.cattle.management.v2.GlobalRole.spec",
+							Description: "The spec for the project",
+						},
+						"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+							ResourceFields: map[string]definitionField{
+								"annotations": {
+									Type:        "map",
+									SubType:     "string",
+									Description: "annotations of the resource",
+								},
+								"name": {
+									Type:        "string",
+									SubType:     "",
+									Description: "name of the resource",
+								},
+							},
+							Type:        "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+							Description: "Object Metadata",
+						},
+					},
+				},
+			},
+		},
+		{
+			name:          "missing category",
+			schemaName:    "no-such-category",
+			models:        defaultModelMap,
+			schemaToModel: defaultSchemaToModel,
+			modelGV:       defaultModelGV,
+			wantError:     true,
+			wantErrorCode: intPtr(404),
+		},
+		{
+			name:       "deployment scale subresource",
+			schemaName: "apps.deployment/scale",
+			models:     map[schema.GroupVersion]*proto.Models{appsGV: &defaultModels},
+			schemaToModel: map[string]string{
+				"apps.deployment.scale": "io.k8s.api.autoscaling.v1.Scale",
+			},
+			modelGV: map[string]schema.GroupVersion{
+				"io.k8s.api.autoscaling.v1.Scale": appsGV,
+			},
+			wantObject: &types.APIObject{
+				ID:   "apps.deployment/scale",
+				Type: "schemaDefinition",
+				Object: schemaDefinition{
+					DefinitionType: "io.k8s.api.autoscaling.v1.Scale",
+					Definitions: map[string]definition{
+						"io.k8s.api.autoscaling.v1.Scale": {
+							ResourceFields: map[string]definitionField{
+								"apiVersion": {
+									Type:        "string",
+									Description: "The APIVersion of this resource",
+								},
+								"kind": {
+									Type:        "string",
+									Description: "The kind",
+								},
+								"metadata": {
+									Type:        "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+									Description: "Standard object metadata",
+								},
+								"spec": {
+									Type:        "io.k8s.api.autoscaling.v1.ScaleSpec",
+									Description: "defines the behavior of the scale",
+								},
+								"status": {
+									Type:        "io.k8s.api.autoscaling.v1.ScaleStatus",
+									Description: "current status of the scale",
+								},
+							},
+							Type:        "io.k8s.api.autoscaling.v1.Scale",
+							Description: "Scale represents a scaling request for a resource.",
+						},
+						"io.k8s.api.autoscaling.v1.ScaleSpec": {
+							ResourceFields: map[string]definitionField{
+								"replicas": {
+									Type:        "integer",
+									Description: "desired number of instances for the scaled object.",
+								},
+							},
+							Type:        "io.k8s.api.autoscaling.v1.ScaleSpec",
+							Description: "ScaleSpec describes the attributes of a scale subresource.",
+						},
+						"io.k8s.api.autoscaling.v1.ScaleStatus": {
+							ResourceFields: map[string]definitionField{
+								"replicas": {
+									Type:        "integer",
+									Description: "actual number of observed instances of the scaled object.",
+									Required:    true,
+								},
+								"selector": {
+									Type:        "string",
+									Description: "label query over pods that should match the replicas count.",
+								},
+							},
+							Type:        "io.k8s.api.autoscaling.v1.ScaleStatus",
+							Description: "ScaleStatus represents the current status of a scale subresource.",
+						},
+						"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+							ResourceFields: map[string]definitionField{
+								"annotations": {
+									Type:        "map",
+									SubType:     "string",
+									Description: "annotations of the resource",
+								},
+								"name": {
+									Type:        "string",
+									SubType:     "",
+									Description: "name of the resource",
+								},
+							},
+							Type:        "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta",
+							Description: "Object Metadata",
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -267,6 +805,8 @@ func Test_byID(t *testing.T) {
 			handler := SchemaDefinitionHandler{
 				models:        test.models,
 				schemaToModel: test.schemaToModel,
+				modelGV:       test.modelGV,
+				categories:    test.categories,
 			}
 			request := types.APIRequest{
 				Schemas: schemas,
@@ -309,9 +849,11 @@ func buildDefaultDiscovery() (*fakeDiscovery, error) {
 			}.String(),
 			APIResources: []metav1.APIResource{
 				{
-					Group:   "management.cattle.io",
-					Kind:    "GlobalRole",
-					Version: "v2",
+					Group:      "management.cattle.io",
+					Kind:       "GlobalRole",
+					Version:    "v2",
+					Name:       "globalroles",
+					Categories: []string{"all"},
 				},
 			},
 		},
@@ -322,9 +864,11 @@ func buildDefaultDiscovery() (*fakeDiscovery, error) {
 			}.String(),
 			APIResources: []metav1.APIResource{
 				{
-					Group:   "management.cattle.io",
-					Kind:    "GlobalRole",
-					Version: "v2",
+					Group:      "management.cattle.io",
+					Kind:       "GlobalRole",
+					Version:    "v2",
+					Name:       "globalroles",
+					Categories: []string{"all"},
 				},
 			},
 		},
@@ -343,6 +887,7 @@ type fakeDiscovery struct {
 	Document          *openapi_v2.Document
 	GroupResourcesErr error
 	DocumentErr       error
+	V3Paths           map[string]openapi.GroupVersion
 }
 
 // ServerGroupsAndResources is the only method we actually need for the test - just returns what is on the struct
@@ -366,5 +911,35 @@ func (f *fakeDiscovery) ServerVersion() (*version.Info, error) { return nil, nil
 func (f *fakeDiscovery) OpenAPISchema() (*openapi_v2.Document, error) {
 	return f.Document, f.DocumentErr
 }
-func (f *fakeDiscovery) OpenAPIV3() openapi.Client                { return nil }
+
+// OpenAPIV3 serves f.V3Paths, if any were configured; Refresh is expected to fall back to the v2
+// document for any GroupVersion not covered by it (and for every GroupVersion when V3Paths is nil).
+func (f *fakeDiscovery) OpenAPIV3() openapi.Client {
+	if f.V3Paths == nil {
+		return nil
+	}
+	return &fakeOpenAPIV3Client{paths: f.V3Paths}
+}
 func (f *fakeDiscovery) WithLegacy() discovery.DiscoveryInterface { return f }
+
+// fakeOpenAPIV3Client serves a fixed set of per-GroupVersion paths, so tests can exercise
+// resolveGVModels' OpenAPI v3 branch without a real apiserver.
+type fakeOpenAPIV3Client struct {
+	paths map[string]openapi.GroupVersion
+}
+
+func (f *fakeOpenAPIV3Client) Paths() (map[string]openapi.GroupVersion, error) {
+	return f.paths, nil
+}
+
+// fakeOpenAPIV3GroupVersion serves a fixed document body (or error) for a single OpenAPI v3 path.
+type fakeOpenAPIV3GroupVersion struct {
+	raw []byte
+	err error
+}
+
+func (f *fakeOpenAPIV3GroupVersion) Schema(contentType string) ([]byte, error) {
+	return f.raw, f.err
+}
+
+func (f *fakeOpenAPIV3GroupVersion) ServerRelativeURL() string { return "" }
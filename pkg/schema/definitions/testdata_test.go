@@ -0,0 +1,282 @@
+package definitions
+
+// openapi_raw is a small, hand-rolled OpenAPI v2 document used to exercise Refresh and byIDHandler
+// without needing a real apiserver. It models a single CRD-backed type (GlobalRole) plus the
+// handful of shared/edge-case definitions the tests assert against.
+const openapi_raw = `
+{
+  "swagger": "2.0",
+  "info": {
+    "title": "test",
+    "version": "v0.0.0"
+  },
+  "paths": {},
+  "definitions": {
+    "io.cattle.management.v2.GlobalRole": {
+      "description": "A Global Role V2 provides Global Permissions in Rancher",
+      "type": "object",
+      "properties": {
+        "apiVersion": {
+          "type": "string",
+          "description": "The APIVersion of this resource"
+        },
+        "kind": {
+          "type": "string",
+          "description": "The kind"
+        },
+        "metadata": {
+          "description": "The metadata",
+          "$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"
+        },
+        "spec": {
+          "description": "The spec for the project",
+          "$ref": "#/definitions/io.cattle.management.v2.GlobalRole.spec"
+        }
+      },
+      "x-kubernetes-group-version-kind": [
+        {
+          "group": "management.cattle.io",
+          "version": "v2",
+          "kind": "GlobalRole"
+        }
+      ]
+    },
+    "io.cattle.management.v2.GlobalRole.spec": {
+      "description": "The spec for the project",
+      "type": "object",
+      "required": [
+        "clusterName",
+        "displayName"
+      ],
+      "properties": {
+        "clusterName": {
+          "type": "string",
+          "description": "The name of the cluster"
+        },
+        "displayName": {
+          "type": "string",
+          "description": "The UI readable name"
+        },
+        "newField": {
+          "type": "string",
+          "description": "A new field not present in v1"
+        },
+        "notRequired": {
+          "type": "boolean",
+          "description": "Some field that isn't required"
+        }
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "description": "Object Metadata",
+      "type": "object",
+      "properties": {
+        "annotations": {
+          "type": "object",
+          "description": "annotations of the resource",
+          "additionalProperties": {
+            "type": "string"
+          }
+        },
+        "name": {
+          "type": "string",
+          "description": "name of the resource"
+        }
+      }
+    },
+    "io.management.cattle.NotAKind": {
+      "description": "A definition that isn't a kind, used to exercise the not-a-kind error path",
+      "type": "string"
+    },
+    "io.cattle.other.v1.OtherThing": {
+      "description": "An unrelated type from a second group, used to test incremental refresh",
+      "type": "object",
+      "properties": {
+        "apiVersion": {
+          "type": "string",
+          "description": "The APIVersion of this resource"
+        }
+      },
+      "x-kubernetes-group-version-kind": [
+        {
+          "group": "other.cattle.io",
+          "version": "v1",
+          "kind": "OtherThing"
+        }
+      ]
+    },
+    "io.k8s.api.apps.v1.Deployment": {
+      "description": "Deployment enables declarative updates for Pods and ReplicaSets.",
+      "type": "object",
+      "properties": {
+        "apiVersion": {
+          "type": "string",
+          "description": "The APIVersion of this resource"
+        },
+        "kind": {
+          "type": "string",
+          "description": "The kind"
+        }
+      },
+      "x-kubernetes-group-version-kind": [
+        {
+          "group": "apps",
+          "version": "v1",
+          "kind": "Deployment"
+        }
+      ]
+    },
+    "io.k8s.api.autoscaling.v1.Scale": {
+      "description": "Scale represents a scaling request for a resource.",
+      "type": "object",
+      "properties": {
+        "apiVersion": {
+          "type": "string",
+          "description": "The APIVersion of this resource"
+        },
+        "kind": {
+          "type": "string",
+          "description": "The kind"
+        },
+        "metadata": {
+          "description": "Standard object metadata",
+          "$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"
+        },
+        "spec": {
+          "description": "defines the behavior of the scale",
+          "$ref": "#/definitions/io.k8s.api.autoscaling.v1.ScaleSpec"
+        },
+        "status": {
+          "description": "current status of the scale",
+          "$ref": "#/definitions/io.k8s.api.autoscaling.v1.ScaleStatus"
+        }
+      },
+      "x-kubernetes-group-version-kind": [
+        {
+          "group": "autoscaling",
+          "version": "v1",
+          "kind": "Scale"
+        }
+      ]
+    },
+    "io.k8s.api.autoscaling.v1.ScaleSpec": {
+      "description": "ScaleSpec describes the attributes of a scale subresource.",
+      "type": "object",
+      "properties": {
+        "replicas": {
+          "type": "integer",
+          "description": "desired number of instances for the scaled object."
+        }
+      }
+    },
+    "io.k8s.api.autoscaling.v1.ScaleStatus": {
+      "description": "ScaleStatus represents the current status of a scale subresource.",
+      "type": "object",
+      "required": [
+        "replicas"
+      ],
+      "properties": {
+        "replicas": {
+          "type": "integer",
+          "description": "actual number of observed instances of the scaled object."
+        },
+        "selector": {
+          "type": "string",
+          "description": "label query over pods that should match the replicas count."
+        }
+      }
+    },
+    "io.k8s.api.autoscaling.v2beta2.Scale": {
+      "description": "Scale represents a scaling request for a resource (v2beta2), used to exercise subresource disambiguation across groups that both define a Scale kind.",
+      "type": "object",
+      "properties": {
+        "apiVersion": {
+          "type": "string",
+          "description": "The APIVersion of this resource"
+        }
+      },
+      "x-kubernetes-group-version-kind": [
+        {
+          "group": "autoscaling",
+          "version": "v2beta2",
+          "kind": "Scale"
+        }
+      ]
+    }
+  }
+}
+`
+
+// openapiV3Raw is a per-GroupVersion OpenAPI v3 document (the shape served by the apiserver's
+// "/openapi/v3/apis/<group>/<version>" endpoint) used to exercise resolveGVModels' v3 branch -
+// fetching, parsing and fingerprinting a per-GV document - independently of the v2 fallback path the
+// rest of this file's tests cover. It describes a single kind, ThirdThing.
+const openapiV3Raw = `
+{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "test",
+    "version": "v0.0.0"
+  },
+  "paths": {},
+  "components": {
+    "schemas": {
+      "io.cattle.other.v3.ThirdThing": {
+        "description": "A type served through OpenAPI v3 rather than the aggregated v2 document",
+        "type": "object",
+        "properties": {
+          "apiVersion": {
+            "type": "string",
+            "description": "The APIVersion of this resource"
+          }
+        },
+        "x-kubernetes-group-version-kind": [
+          {
+            "group": "other.cattle.io",
+            "version": "v3",
+            "kind": "ThirdThing"
+          }
+        ]
+      }
+    }
+  }
+}
+`
+
+// openapiV3RawUpdated is openapiV3Raw with a field added to ThirdThing, used to assert that Refresh
+// re-parses a GV's v3 document once its content (not just its raw byte length) actually changes.
+const openapiV3RawUpdated = `
+{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "test",
+    "version": "v0.0.0"
+  },
+  "paths": {},
+  "components": {
+    "schemas": {
+      "io.cattle.other.v3.ThirdThing": {
+        "description": "A type served through OpenAPI v3 rather than the aggregated v2 document",
+        "type": "object",
+        "properties": {
+          "apiVersion": {
+            "type": "string",
+            "description": "The APIVersion of this resource"
+          },
+          "newField": {
+            "type": "string",
+            "description": "A field added after the first Refresh"
+          }
+        },
+        "x-kubernetes-group-version-kind": [
+          {
+            "group": "other.cattle.io",
+            "version": "v3",
+            "kind": "ThirdThing"
+          }
+        ]
+      }
+    }
+  }
+}
+`